@@ -0,0 +1,56 @@
+//go:build bpf
+
+package main
+
+import (
+	"github.com/assafmo/joincap/minheap"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// packetFilter evaluates a BPF expression against packets, compiling one
+// gopacket/pcap.BPF program per link type the first time that link type is
+// seen (each packet is matched against its own input interface's link type,
+// and different input files or pcapng interfaces can carry different link
+// types). gopacket/pcap wraps libpcap via cgo, which is why -f/-R are only
+// available in a binary built with -tags bpf; see filter_stub.go for the
+// default build.
+type packetFilter struct {
+	expr     string
+	invert   bool
+	programs map[layers.LinkType]*pcap.BPF
+}
+
+// newPacketFilter returns a packetFilter for expr, or (nil, nil) if expr is
+// empty (no filter requested). expr is not compiled here: matches compiles
+// it for each link type on first use, so an invalid expression is only
+// reported by matches.
+func newPacketFilter(expr string, invert bool) (*packetFilter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return &packetFilter{expr: expr, invert: invert, programs: map[layers.LinkType]*pcap.BPF{}}, nil
+}
+
+// String returns the filter's BPF expression, for log messages.
+func (f *packetFilter) String() string {
+	return f.expr
+}
+
+func (f *packetFilter) matches(linkType layers.LinkType, packet minheap.Packet) (bool, error) {
+	program, ok := f.programs[linkType]
+	if !ok {
+		var err error
+		program, err = pcap.NewBPF(linkType, int(maxSnaplen), f.expr)
+		if err != nil {
+			return false, err
+		}
+		f.programs[linkType] = program
+	}
+
+	matched := program.Matches(packet.CaptureInfo, packet.Data)
+	if f.invert {
+		matched = !matched
+	}
+	return matched, nil
+}
@@ -0,0 +1,36 @@
+//go:build !bpf
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/assafmo/joincap/minheap"
+	"github.com/google/gopacket/layers"
+)
+
+// packetFilter is never constructed in a default build: -f/-R need
+// gopacket/pcap, which wraps libpcap via cgo, so filtering is only
+// available in a binary built with -tags bpf. See filter.go.
+type packetFilter struct{}
+
+// newPacketFilter returns (nil, nil) if expr is empty (no filter
+// requested), or an error otherwise, since this build has no filter
+// implementation.
+func newPacketFilter(expr string, invert bool) (*packetFilter, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("-f/-R require a binary built with -tags bpf (needs libpcap-dev)")
+}
+
+// String returns the filter's BPF expression, for log messages. Never
+// called in practice: newPacketFilter never returns a non-nil packetFilter
+// in this build.
+func (f *packetFilter) String() string {
+	return ""
+}
+
+func (f *packetFilter) matches(linkType layers.LinkType, packet minheap.Packet) (bool, error) {
+	return false, fmt.Errorf("-f/-R require a binary built with -tags bpf (needs libpcap-dev)")
+}
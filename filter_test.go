@@ -0,0 +1,71 @@
+//go:build bpf
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestFilterMatch a filter that matches packets present in the input
+// should produce a non-empty output
+func TestFilterMatch(t *testing.T) {
+	outputFile, err := ioutil.TempFile("", "joincap_output_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	inputFile, err := ioutil.TempFile("", "joincap_input_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile.Close()
+	defer os.Remove(inputFile.Name())
+	inputFilePath := inputFile.Name()
+	writeSyntheticTCPPcap(t, inputFilePath, 10, 80)
+
+	joincap([]string{"joincap", "-f", "tcp", "-w", outputFile.Name(), inputFilePath, inputFilePath})
+
+	outputPacketCount, err := packetCount(outputFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outputPacketCount == 0 {
+		t.Fatalf("expected at least one tcp packet, got 0\n")
+	}
+}
+
+// TestFilterNoMatch a filter that matches nothing should produce an
+// empty output file
+func TestFilterNoMatch(t *testing.T) {
+	outputFile, err := ioutil.TempFile("", "joincap_output_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	inputFile, err := ioutil.TempFile("", "joincap_input_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile.Close()
+	defer os.Remove(inputFile.Name())
+	inputFilePath := inputFile.Name()
+	writeSyntheticTCPPcap(t, inputFilePath, 10, 80)
+
+	joincap([]string{"joincap", "-f", "port 1", "-w", outputFile.Name(), inputFilePath, inputFilePath})
+
+	outputPacketCount, err := packetCount(outputFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outputPacketCount != 0 {
+		t.Fatalf("expected 0 packets with a non-matching filter, got %d\n", outputPacketCount)
+	}
+}
@@ -0,0 +1,73 @@
+// Package termstatus renders a single status line that's redrawn in place
+// on an interactive terminal, borrowed from restic's internal/ui/termstatus
+// package. Regular log output is interleaved by clearing the status line,
+// printing the log line above it, then redrawing the status line below.
+package termstatus
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Terminal prints a redrawn status line and, in between, ordinary log lines.
+// On a non-interactive output it degrades to plain sequential printing of
+// the log lines only: Update records the status line but never writes it.
+type Terminal struct {
+	w          io.Writer
+	isTerminal bool
+
+	mu         sync.Mutex
+	lastStatus string
+}
+
+// New returns a Terminal that writes to w. isTerminal should be true only
+// when w is an interactive TTY capable of carriage-return redraws.
+func New(w io.Writer, isTerminal bool) *Terminal {
+	return &Terminal{w: w, isTerminal: isTerminal}
+}
+
+// Print writes line as a log message, momentarily clearing the status line
+// so the two don't overlap, then redraws the status line underneath it.
+func (t *Terminal) Print(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clearLocked()
+	fmt.Fprintln(t.w, line)
+	t.drawLocked(t.lastStatus)
+}
+
+// Update overwrites the current status line with status.
+func (t *Terminal) Update(status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clearLocked()
+	t.drawLocked(status)
+	t.lastStatus = status
+}
+
+// Finish clears the status line and leaves the cursor on an empty line.
+func (t *Terminal) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.clearLocked()
+	t.lastStatus = ""
+}
+
+func (t *Terminal) clearLocked() {
+	if !t.isTerminal || t.lastStatus == "" {
+		return
+	}
+	fmt.Fprint(t.w, "\r", strings.Repeat(" ", len(t.lastStatus)), "\r")
+}
+
+func (t *Terminal) drawLocked(status string) {
+	if !t.isTerminal || status == "" {
+		return
+	}
+	fmt.Fprint(t.w, status)
+}
@@ -1,38 +1,150 @@
-// Merge multiple pcap files together, gracefully.
+// Merge multiple pcap/pcapng files together, gracefully.
 //
 //  Usage:
 //    joincap [OPTIONS] InFiles...
 //
 //  Application Options:
-//    -v, --verbose  Explain when skipping packets or entire input files
-//    -V, --version  Print the version and exit
-//    -w=            Sets the output filename. If the name is '-', stdout will be used (default: -)
+//    -v, --verbose      Explain when skipping packets or entire input files
+//    -V, --version      Print the version and exit
+//    -w=                Sets the output filename. If the name is '-', stdout will be used (default: -)
+//    -F=                Sets the output format: pcap, pcapng or auto (default: auto)
+//    -z=                Compresses the output: none, gzip or zstd (default: inferred from the -w extension)
+//    -f=                Only merges packets matching this BPF filter expression (e.g. "tcp")
+//    -R                 Inverts the -f filter, keeping only packets that don't match
+//    -S                 Split mode: split the single input file instead of merging
+//    --by-count=        In split mode, split into files of N packets each
+//    --by-size=         In split mode, split into files of approximately SZ bytes each (e.g. "64MB")
+//    --by-interval=     In split mode, split into files covering a DUR wall-clock window each (e.g. "1m")
+//    --by-flow          In split mode, split into one file per 5-tuple flow
+//    --progress=        Shows a live progress line: auto, always or never (default: auto)
+//    -j=                Caps how many input files are decoded concurrently (default: unlimited)
+//    --dedup[=]         Drops packets seen again within window of an earlier one (window default: 1ms)
+//    --dedup-offset=    Skips this many bytes of each packet before fingerprinting for --dedup (default: 0)
 //
 //  Help Options:
-//    -h, --help     Show this help message
+//    -h, --help         Show this help message
+//
+// -f and -R need gopacket/pcap, which wraps libpcap via cgo, so a default
+// build has no filter support; build with -tags bpf (and libpcap-dev
+// installed) to enable them. See filter.go and filter_stub.go.
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
 	"container/heap"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/assafmo/joincap/internal/ui/termstatus"
 	"github.com/assafmo/joincap/minheap"
+	xxhash "github.com/cespare/xxhash/v2"
 	humanize "github.com/dustin/go-humanize"
+	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
 	flags "github.com/jessevdk/go-flags"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/term"
 )
 
 const version = "0.10.0"
 const maxSnaplen uint32 = 262144
 
+// channelBufferSize is the capacity of each input file's decode channel.
+// Bounding it lets a fast decoder run ahead of the merge loop without
+// unbounded memory growth, while still decoupling per-file I/O from merging.
+const channelBufferSize = 1024
+
+// pcapng Section Header Block magic number, as read from the first 4 bytes
+// of a block starting at the beginning of the file.
+const ngMagic = 0x0a0d0d0a
+
+// magic byte sequences for the transparently-supported input/output
+// compression formats.
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	bzip2Magic = []byte{0x42, 0x5a, 0x68} // "BZh"
+)
+
 var previousTimestamp int64
 
+// statusTerminal and progress are non-nil for the duration of a merge run
+// with --progress enabled; logVerbose routes through statusTerminal so log
+// lines don't fight with the redrawn status line.
+var statusTerminal *termstatus.Terminal
+var progress *mergeProgress
+
+// dedup is non-nil for the duration of a merge run with --dedup enabled.
+var dedup *deduplicator
+
+// logVerbose prints a verbose log line, either through statusTerminal (so
+// it's interleaved cleanly with the live progress line) or, when progress
+// reporting isn't active, straight to the standard logger.
+func logVerbose(format string, args ...interface{}) {
+	line := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+	if statusTerminal != nil {
+		statusTerminal.Print(line)
+		return
+	}
+	log.Println(line)
+}
+
+// mergeProgress tracks the running totals behind the "merged X/Y files, Z
+// packets, A MB/B MB (P%), ETA" status line.
+type mergeProgress struct {
+	totalInputSizeBytes int64
+	totalFiles          int64
+	bytesRead           int64 // atomic
+	filesCompleted      int64 // atomic
+	packetsWritten      int64 // atomic
+	startTime           time.Time
+}
+
+func (p *mergeProgress) render() string {
+	bytesRead := atomic.LoadInt64(&p.bytesRead)
+	filesCompleted := atomic.LoadInt64(&p.filesCompleted)
+	packetsWritten := atomic.LoadInt64(&p.packetsWritten)
+
+	var percent float64
+	if p.totalInputSizeBytes > 0 {
+		percent = float64(bytesRead) / float64(p.totalInputSizeBytes) * 100
+	}
+
+	eta := "?"
+	if percent > 0 && percent < 100 {
+		remaining := time.Since(p.startTime).Seconds() * (100/percent - 1)
+		eta = time.Duration(remaining * float64(time.Second)).Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("merged %d/%d files, %d packets, %s/%s (%.1f%%), ETA %s",
+		filesCompleted, p.totalFiles, packetsWritten,
+		humanize.IBytes(uint64(bytesRead)), humanize.IBytes(uint64(p.totalInputSizeBytes)),
+		percent, eta)
+}
+
+// countingReader wraps an input file's reader and tracks how many bytes
+// have been read from it so far, feeding mergeProgress's running byte count.
+type countingReader struct {
+	r       io.Reader
+	counter *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
+
 func main() {
 	err := joincap(os.Args)
 	if err != nil {
@@ -47,6 +159,19 @@ func joincap(args []string) error {
 		Verbose        bool   `short:"v" long:"verbose" description:"Explain when skipping packets or input files"`
 		Version        bool   `short:"V" long:"version" description:"Print the version and exit"`
 		OutputFilePath string `short:"w" default:"-" description:"Sets the output filename. If the name is '-', stdout will be used"`
+		OutputFormat   string `short:"F" long:"format" default:"auto" choice:"pcap" choice:"pcapng" choice:"auto" description:"Sets the output format: pcap, pcapng or auto (auto uses pcapng if any input file is pcapng)"`
+		Compression    string `short:"z" long:"compression" default:"none" choice:"none" choice:"gzip" choice:"zstd" description:"Compresses the output: none, gzip or zstd (default: inferred from the -w extension)"`
+		Filter         string `short:"f" long:"filter" description:"Only merges packets matching this BPF filter expression (e.g. \"tcp\")"`
+		InvertFilter   bool   `short:"R" long:"invert-filter" description:"Inverts the -f filter, keeping only packets that don't match"`
+		Split          bool   `short:"S" long:"split" description:"Split mode: split the single input file instead of merging"`
+		ByCount        uint64 `long:"by-count" description:"In split mode, split into files of N packets each"`
+		BySize         string `long:"by-size" description:"In split mode, split into files of approximately SZ bytes each (e.g. \"64MB\")"`
+		ByInterval     string `long:"by-interval" description:"In split mode, split into files covering a DUR wall-clock window each (e.g. \"1m\")"`
+		ByFlow         bool   `long:"by-flow" description:"In split mode, split into one file per 5-tuple flow"`
+		Progress       string `long:"progress" default:"auto" choice:"auto" choice:"always" choice:"never" description:"Shows a live progress line: auto, always or never"`
+		Concurrency    int    `short:"j" long:"jobs" default:"0" description:"Caps how many input files are decoded concurrently (0 = unlimited)"`
+		Dedup          string `long:"dedup" optional:"yes" optional-value:"1ms" description:"Drops packets seen again within window of an earlier one (e.g. \"--dedup\" or \"--dedup=5ms\")"`
+		DedupOffset    int    `long:"dedup-offset" default:"0" description:"Skips this many bytes of each packet before fingerprinting for --dedup (e.g. to ignore VLAN/MPLS headers)"`
 		Rest           struct {
 			InFiles []string
 		} `positional-args:"yes" required:"yes"`
@@ -74,7 +199,7 @@ func joincap(args []string) error {
 	}
 
 	if cmdFlags.Verbose {
-		log.Printf("joincap v%s - https://github.com/assafmo/joincap\n", version)
+		logVerbose("joincap v%s - https://github.com/assafmo/joincap\n", version)
 	}
 
 	minTimeHeap := minheap.PacketHeap{}
@@ -82,11 +207,77 @@ func joincap(args []string) error {
 
 	inputFilePaths := cmdFlags.Rest.InFiles[1:]
 
-	linkType, err := initHeapWithInputFiles(inputFilePaths, &minTimeHeap, cmdFlags.Verbose)
+	if cmdFlags.Split {
+		if len(inputFilePaths) != 1 {
+			return fmt.Errorf("-S/--split takes exactly one input file, got %d", len(inputFilePaths))
+		}
+		return splitcap(inputFilePaths[0], cmdFlags.OutputFilePath, splitMode{
+			byCount:    cmdFlags.ByCount,
+			bySize:     cmdFlags.BySize,
+			byInterval: cmdFlags.ByInterval,
+			byFlow:     cmdFlags.ByFlow,
+		}, cmdFlags.Verbose)
+	}
+
+	if cmdFlags.Dedup != "" {
+		window, err := time.ParseDuration(cmdFlags.Dedup)
+		if err != nil {
+			return fmt.Errorf("--dedup %q: %v", cmdFlags.Dedup, err)
+		}
+		if cmdFlags.DedupOffset < 0 {
+			return fmt.Errorf("--dedup-offset %d: must not be negative", cmdFlags.DedupOffset)
+		}
+		dedup = newDeduplicator(window, cmdFlags.DedupOffset)
+	}
+	defer func() { dedup = nil }()
+
+	showProgress := cmdFlags.Progress == "always" ||
+		(cmdFlags.Progress == "auto" && term.IsTerminal(int(os.Stderr.Fd())))
+	if showProgress {
+		statusTerminal = termstatus.New(os.Stderr, true)
+		progress = &mergeProgress{startTime: time.Now()}
+	}
+	defer func() {
+		if statusTerminal != nil {
+			statusTerminal.Finish()
+		}
+		statusTerminal = nil
+		progress = nil
+	}()
+
+	filter, err := newPacketFilter(cmdFlags.Filter, cmdFlags.InvertFilter)
+	if err != nil {
+		return err
+	}
+
+	linkType, snaplen, outputInterfaces, anyInputIsNg, sectionInfo, err := initHeapWithInputFiles(inputFilePaths, &minTimeHeap, filter, cmdFlags.Verbose, cmdFlags.Concurrency)
 	if err != nil {
 		return fmt.Errorf("cannot initialize merge: %v", err)
 	}
 
+	if statusTerminal != nil {
+		stopProgress := make(chan struct{})
+		progressDone := make(chan struct{})
+		go func() {
+			defer close(progressDone)
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					statusTerminal.Update(progress.render())
+				case <-stopProgress:
+					return
+				}
+			}
+		}()
+		defer func() {
+			close(stopProgress)
+			<-progressDone
+			statusTerminal.Update(progress.render())
+		}()
+	}
+
 	outputFile := os.Stdout
 	if cmdFlags.OutputFilePath != "-" {
 		outputFile, err = os.Create(cmdFlags.OutputFilePath)
@@ -95,38 +286,57 @@ func joincap(args []string) error {
 		}
 		defer outputFile.Close()
 	}
-	bufferedFileWriter := bufio.NewWriter(outputFile)
+	compressedWriter, compressorCloser, err := wrapOutputCompressor(outputFile, cmdFlags.Compression, cmdFlags.OutputFilePath, cmdFlags.Verbose)
+	if err != nil {
+		return fmt.Errorf("cannot set up output compression: %v", err)
+	}
+	if compressorCloser != nil {
+		defer compressorCloser.Close()
+	}
+
+	bufferedFileWriter := bufio.NewWriter(compressedWriter)
 	defer bufferedFileWriter.Flush()
 
 	if cmdFlags.Verbose {
-		log.Printf("writing to %s\n", outputFile.Name())
+		logVerbose("writing to %s\n", outputFile.Name())
+	}
+
+	useNg := cmdFlags.OutputFormat == "pcapng" || (cmdFlags.OutputFormat == "auto" && anyInputIsNg)
+
+	var ngWriter *pcapgo.NgWriter
+	var writer *pcapgo.Writer
+	if useNg {
+		ngWriter, err = newNgWriterWithInterfaces(bufferedFileWriter, outputInterfaces, sectionInfo)
+		if err != nil {
+			return fmt.Errorf("cannot create pcapng writer: %v", err)
+		}
+	} else {
+		writer = pcapgo.NewWriter(bufferedFileWriter)
+		writer.WriteFileHeader(snaplen, linkType)
 	}
 
-	writer := pcapgo.NewWriter(bufferedFileWriter)
-	writer.WriteFileHeader(maxSnaplen, linkType)
 	for minTimeHeap.Len() > 0 {
 		// find the earliest packet and write it to the output file
 		earliestPacket := heap.Pop(&minTimeHeap).(minheap.Packet)
-		write(writer, earliestPacket, cmdFlags.Verbose)
+		write(writer, ngWriter, earliestPacket, cmdFlags.Verbose)
 
 		var earliestHeapTime int64
 		if minTimeHeap.Len() > 0 {
 			earliestHeapTime = minTimeHeap[0].Timestamp
 		}
 		for {
-			// read the next packet from the source of the last written packet
-			nextPacket, err := readNext(
-				earliestPacket.Reader,
-				earliestPacket.InputFile,
-				cmdFlags.Verbose,
-				false)
+			// pull the next packet from the source of the last written packet
+			nextPacket, err := pullNext(earliestPacket.Channel, earliestPacket.InputFile, filter, outputInterfaces, cmdFlags.Verbose, false)
 			if err == io.EOF {
 				break
 			}
+			if err != nil {
+				return fmt.Errorf("cannot compile filter %q: %v", cmdFlags.Filter, err)
+			}
 
 			if nextPacket.Timestamp <= earliestHeapTime {
 				// this is the earliest packet, write it to the output file
-				write(writer, nextPacket, cmdFlags.Verbose)
+				write(writer, ngWriter, nextPacket, cmdFlags.Verbose)
 				continue
 			}
 
@@ -135,47 +345,187 @@ func joincap(args []string) error {
 			break
 		}
 	}
+
+	if ngWriter != nil {
+		ngWriter.Flush()
+	}
+
+	if cmdFlags.Verbose && dedup != nil {
+		logVerbose("dropped %d duplicate packets\n", dedup.dropped)
+	}
+
 	return nil
 }
 
-func initHeapWithInputFiles(inputFilePaths []string, minTimeHeap *minheap.PacketHeap, verbose bool) (layers.LinkType, error) {
+// initHeapWithInputFiles opens every input file, auto-detecting whether it's
+// classic pcap or pcapng by its magic number, starts a goroutine per input
+// that decodes its packets onto a bounded channel, pushes each input's first
+// packet onto minTimeHeap, and returns the link type and snaplen to use for
+// classic pcap output (snaplen is the largest snaplen seen among classic
+// pcap inputs, so a merge never under-declares capacity for a packet it
+// actually wrote, falling back to maxSnaplen if every input was pcapng), the
+// full list of interfaces to declare in pcapng output (one per classic pcap
+// input, or a copy of each pcapng input's own Interface Description
+// Blocks), and whether any input file was itself pcapng.
+//
+// concurrency caps how many of those decode goroutines may be actively
+// reading at once (0 = unlimited); it only throttles decoding, not the
+// number of input files that can be open or queued at a time.
+//
+// filter, if non-nil, is applied to each file's first packet exactly like
+// every later packet (both go through pullNext), so a filter that matches
+// nothing can still legitimately skip a file entirely here.
+//
+// The returned pcapgo.NgSectionInfo is copied from the first pcapng input
+// file's own Section Header Block, so a pcapng merge preserves its
+// hardware/OS/application metadata instead of stamping a generic one; if no
+// input file is pcapng, it's pcapgo.DefaultNgWriterOptions.SectionInfo.
+func initHeapWithInputFiles(inputFilePaths []string, minTimeHeap *minheap.PacketHeap, filter *packetFilter, verbose bool, concurrency int) (layers.LinkType, uint32, []pcapgo.NgInterface, bool, pcapgo.NgSectionInfo, error) {
 	var totalInputSizeBytes int64
 	var linkType layers.LinkType
+	var snaplen uint32
+	var outputInterfaces []pcapgo.NgInterface
+	var anyInputIsNg bool
+	sectionInfo := pcapgo.DefaultNgWriterOptions.SectionInfo
+	var haveSectionInfo bool
+
+	var decodeSem chan struct{}
+	if concurrency > 0 {
+		decodeSem = make(chan struct{}, concurrency)
+	}
+
 	for _, inputPcapPath := range inputFilePaths {
 		inputFile, err := os.Open(inputPcapPath)
 		if err != nil {
 			if verbose {
-				log.Printf("%s: %v (skipping this file)\n", inputPcapPath, err)
+				logVerbose("%s: %v (skipping this file)\n", inputPcapPath, err)
 			}
 			continue
 		}
 
-		reader, err := pcapgo.NewReader(inputFile)
+		fStat, _ := inputFile.Stat()
+		totalInputSizeBytes += fStat.Size()
+
+		var fileReader io.Reader = inputFile
+		if progress != nil {
+			atomic.AddInt64(&progress.totalFiles, 1)
+			progress.totalInputSizeBytes = totalInputSizeBytes
+			fileReader = &countingReader{r: inputFile, counter: &progress.bytesRead}
+		}
+
+		decompressed, decompressor, err := wrapInputDecompressor(fileReader)
 		if err != nil {
 			if verbose {
-				log.Printf("%s: %v (skipping this file)\n", inputFile.Name(), err)
+				logVerbose("%s: %v (skipping this file)\n", inputFile.Name(), err)
 			}
 			continue
 		}
 
-		fStat, _ := inputFile.Stat()
-		totalInputSizeBytes += fStat.Size()
-
-		reader.SetSnaplen(maxSnaplen)
-		if linkType == layers.LinkTypeNull {
-			linkType = reader.LinkType()
-		} else if linkType != reader.LinkType() {
-			linkType = layers.LinkTypeEthernet
-		}
-
-		nextPacket, err := readNext(reader, inputFile, verbose, true)
+		isNg, decompressed, err := detectPcapNg(decompressed)
 		if err != nil {
 			if verbose {
-				log.Printf("%s: %v before first packet (skipping this file)\n", inputFile.Name(), err)
+				logVerbose("%s: %v (skipping this file)\n", inputFile.Name(), err)
 			}
 			continue
 		}
 
+		var nextPacket minheap.Packet
+		if isNg {
+			anyInputIsNg = true
+			reader, err := pcapgo.NewNgReader(decompressed, pcapgo.DefaultNgReaderOptions)
+			if err != nil {
+				if verbose {
+					logVerbose("%s: %v (skipping this file)\n", inputFile.Name(), err)
+				}
+				continue
+			}
+
+			if !haveSectionInfo {
+				sectionInfo = reader.SectionInfo()
+				haveSectionInfo = true
+			}
+
+			// every interface declared in this pcapng file is re-mapped to a
+			// fresh slot in outputInterfaces so packets from different input
+			// files never collide on interface ID.
+			interfaceBase := len(outputInterfaces)
+			for i := 0; i < reader.NInterfaces(); i++ {
+				intf, err := reader.Interface(i)
+				if err != nil {
+					break
+				}
+				outputInterfaces = append(outputInterfaces, intf)
+			}
+
+			if linkType == layers.LinkTypeNull && len(outputInterfaces) > 0 {
+				linkType = outputInterfaces[interfaceBase].LinkType
+			}
+
+			ch := make(chan minheap.Packet, channelBufferSize)
+			go decodeNgReaderSource(reader, inputFile, decompressor, interfaceBase, verbose, ch, decodeSem)
+
+			nextPacket, err = pullNext(ch, inputFile, filter, outputInterfaces, verbose, true)
+			if err != nil && err != io.EOF {
+				return linkType, snaplen, outputInterfaces, anyInputIsNg, sectionInfo, fmt.Errorf("%s: %v", inputFile.Name(), err)
+			}
+			if err != nil {
+				if verbose {
+					logVerbose("%s: %v before first packet (skipping this file)\n", inputFile.Name(), err)
+				}
+				continue
+			}
+		} else {
+			reader, err := pcapgo.NewReader(decompressed)
+			if err != nil {
+				if verbose {
+					logVerbose("%s: %v (skipping this file)\n", inputFile.Name(), err)
+				}
+				continue
+			}
+			// capture the input's own snaplen before SetSnaplen below raises
+			// it to maxSnaplen - that call only relaxes the reader's own
+			// internal sanity check against oversized packets, it isn't the
+			// value the merged output's header should claim.
+			inputSnaplen := reader.Snaplen()
+			if inputSnaplen == 0 {
+				inputSnaplen = maxSnaplen
+			}
+			if inputSnaplen > snaplen {
+				snaplen = inputSnaplen
+			}
+			reader.SetSnaplen(maxSnaplen)
+
+			if linkType == layers.LinkTypeNull {
+				linkType = reader.LinkType()
+			} else if linkType != reader.LinkType() {
+				linkType = layers.LinkTypeEthernet
+			}
+
+			// classic pcap files carry no interface metadata of their own,
+			// so synthesize a single-interface IDB for pcapng output.
+			interfaceIndex := len(outputInterfaces)
+			outputInterfaces = append(outputInterfaces, pcapgo.NgInterface{
+				Name:                filepath.Base(inputPcapPath),
+				LinkType:            reader.LinkType(),
+				SnapLength:          maxSnaplen,
+				TimestampResolution: 6,
+			})
+
+			ch := make(chan minheap.Packet, channelBufferSize)
+			go decodeReaderSource(reader, inputFile, decompressor, interfaceIndex, verbose, ch, decodeSem)
+
+			nextPacket, err = pullNext(ch, inputFile, filter, outputInterfaces, verbose, true)
+			if err != nil && err != io.EOF {
+				return linkType, snaplen, outputInterfaces, anyInputIsNg, sectionInfo, fmt.Errorf("%s: %v", inputFile.Name(), err)
+			}
+			if err != nil {
+				if verbose {
+					logVerbose("%s: %v before first packet (skipping this file)\n", inputFile.Name(), err)
+				}
+				continue
+			}
+		}
+
 		heap.Push(minTimeHeap, nextPacket)
 
 		if previousTimestamp == 0 {
@@ -187,67 +537,699 @@ func initHeapWithInputFiles(inputFilePaths []string, minTimeHeap *minheap.Packet
 
 	if verbose {
 		size := humanize.IBytes(uint64(totalInputSizeBytes))
-		log.Printf("merging %d input files of size %s\n", minTimeHeap.Len(), size)
+		logVerbose("merging %d input files of size %s\n", minTimeHeap.Len(), size)
+	}
+
+	if snaplen == 0 {
+		snaplen = maxSnaplen
+	}
+
+	return linkType, snaplen, outputInterfaces, anyInputIsNg, sectionInfo, nil
+}
+
+// peekBytes reads up to n bytes from r and returns them alongside a reader
+// that replays those bytes before the rest of r, so the peek is effectively
+// undone for whatever reads from the returned reader next.
+func peekBytes(r io.Reader, n int) ([]byte, io.Reader, error) {
+	buf := make([]byte, n)
+	read, err := io.ReadFull(r, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, nil, err
+	}
+	buf = buf[:read]
+	return buf, io.MultiReader(bytes.NewReader(buf), r), nil
+}
+
+// wrapInputDecompressor peeks at the first 4 bytes of r to detect gzip, zstd
+// or bzip2 compression and, if found, transparently wraps r with the
+// matching decompressor. The returned io.Closer is the decompressor itself
+// (nil if the input isn't compressed, or is bzip2, which stdlib exposes as
+// a plain io.Reader with no Close method) and must be closed before the
+// underlying input file.
+func wrapInputDecompressor(r io.Reader) (io.Reader, io.Closer, error) {
+	magic, r, err := peekBytes(r, 4)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gzReader, gzReader, nil
+	case bytes.Equal(magic, zstdMagic):
+		zstdReader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zstdReader.IOReadCloser(), zstdReader.IOReadCloser(), nil
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzip2.NewReader(r), nil, nil
+	default:
+		return r, nil, nil
+	}
+}
+
+// detectPcapNg peeks at the first 4 bytes of r (which may already be the
+// output of wrapInputDecompressor) to tell a pcapng Section Header Block
+// from a classic pcap global header. A file too short to hold a magic
+// number at all is reported as not pcapng, the same way it's reported as
+// unrecognized compression by wrapInputDecompressor - the caller skips it
+// like any other malformed input instead of this indexing into magic.
+func detectPcapNg(r io.Reader) (bool, io.Reader, error) {
+	magic, r, err := peekBytes(r, 4)
+	if err != nil {
+		return false, nil, err
+	}
+	if len(magic) < 4 {
+		return false, r, nil
+	}
+
+	magicLE := uint32(magic[0]) | uint32(magic[1])<<8 | uint32(magic[2])<<16 | uint32(magic[3])<<24
+	magicBE := uint32(magic[3]) | uint32(magic[2])<<8 | uint32(magic[1])<<16 | uint32(magic[0])<<24
+
+	return magicLE == ngMagic || magicBE == ngMagic, r, nil
+}
+
+// wrapOutputCompressor picks the output compression format, either from the
+// explicit -z flag or (when it's left at "none") inferred from the output
+// file's extension, and wraps outputFile with the matching encoder. The
+// returned io.Writer is what bufferedFileWriter should wrap; the returned
+// io.Closer (nil if uncompressed) must be closed, and must be closed after
+// bufferedFileWriter is flushed, to flush the compressor's trailer.
+func wrapOutputCompressor(outputFile *os.File, compression string, outputFilePath string, verbose bool) (io.Writer, io.Closer, error) {
+	if compression == "none" {
+		switch filepath.Ext(outputFilePath) {
+		case ".gz":
+			compression = "gzip"
+		case ".zst":
+			compression = "zstd"
+		case ".bz2":
+			if verbose {
+				logVerbose("bzip2 output is not supported, writing uncompressed\n")
+			}
+		}
+	}
+
+	switch compression {
+	case "gzip":
+		gzWriter := gzip.NewWriter(outputFile)
+		return gzWriter, gzWriter, nil
+	case "zstd":
+		zstdWriter, err := zstd.NewWriter(outputFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zstdWriter, zstdWriter, nil
+	default:
+		return outputFile, nil, nil
+	}
+}
+
+// newNgWriterWithInterfaces writes a single Section Header Block - carrying
+// sectionInfo, normally copied from the first pcapng input file so its
+// hardware/OS/application metadata survives the merge - followed by one
+// Interface Description Block per entry in interfaces, preserving each
+// input file's link type, timestamp resolution and interface name.
+func newNgWriterWithInterfaces(w io.Writer, interfaces []pcapgo.NgInterface, sectionInfo pcapgo.NgSectionInfo) (*pcapgo.NgWriter, error) {
+	if len(interfaces) == 0 {
+		interfaces = []pcapgo.NgInterface{{LinkType: layers.LinkTypeEthernet, SnapLength: maxSnaplen}}
+	}
+
+	options := pcapgo.DefaultNgWriterOptions
+	options.SectionInfo = sectionInfo
+
+	ngWriter, err := pcapgo.NewNgWriterInterface(w, interfaces[0], options)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, intf := range interfaces[1:] {
+		if _, err := ngWriter.AddInterface(intf); err != nil {
+			return nil, err
+		}
 	}
 
-	return linkType, nil
+	return ngWriter, nil
 }
 
-func readNext(reader *pcapgo.Reader, inputFile *os.File, verbose bool, isInit bool) (minheap.Packet, error) {
+// pullNext receives decoded packets from ch (an input file's decode
+// goroutine output) until it finds one with a legal timestamp that also
+// matches filter (if any), or ch is closed at EOF. It's called both for a
+// file's very first packet during heap initialization and for every packet
+// afterward, so a packet never reaches the heap - and never reaches write -
+// without passing the same checks. This is also the only place that applies
+// the "packets must arrive within an hour of the previous one" rule, so it's
+// the only place that reads previousTimestamp; it is written only by
+// initHeapWithInputFiles and write. All three run on the merge goroutine,
+// never on the per-input decode goroutines, so no lock is needed.
+func pullNext(ch chan minheap.Packet, inputFile *os.File, filter *packetFilter, outputInterfaces []pcapgo.NgInterface, verbose bool, isInit bool) (minheap.Packet, error) {
+	for packet := range ch {
+		packet.Channel = ch
+		if !isLegalTimestamp(inputFile, packet.CaptureInfo, verbose, isInit) {
+			continue
+		}
+		if filter != nil {
+			matched, err := filter.matches(outputInterfaces[packet.InterfaceIndex].LinkType, packet)
+			if err != nil {
+				return minheap.Packet{}, err
+			}
+			if !matched {
+				if verbose {
+					logVerbose("%s: packet does not match filter %q (skipping this packet)\n", inputFile.Name(), filter.String())
+				}
+				continue
+			}
+		}
+		return packet, nil
+	}
+	return minheap.Packet{}, io.EOF
+}
+
+// closeInput closes the decompression layer (if any) before the underlying
+// input file, so the file's own compressed bytes aren't read again.
+func closeInput(inputFile *os.File, decompressor io.Closer) {
+	if decompressor != nil {
+		decompressor.Close()
+	}
+	inputFile.Close()
+
+	if progress != nil {
+		atomic.AddInt64(&progress.filesCompleted, 1)
+	}
+}
+
+// decodeReaderSource decodes every packet of a classic pcap input on its own
+// goroutine and sends them, in order, on ch; ch is closed once the input is
+// exhausted (after closing inputFile and decompressor). Packets with a
+// decode error or no data are skipped here; timestamp legality is left to
+// the merge goroutine via pullNext, since that check depends on
+// previousTimestamp, which is shared across every input's goroutine.
+//
+// sem, if non-nil, is only held around the ReadPacketData call itself, never
+// across the "ch <-" send: initialization only drains each file's channel
+// down to its first packet before moving to the next file, so a goroutine
+// blocked sending to a full channel must not also be holding a sem slot -
+// otherwise, once every slot is taken by a goroutine stuck on a full
+// channel, no input's channel is ever drained and the whole merge deadlocks.
+func decodeReaderSource(reader *pcapgo.Reader, inputFile *os.File, decompressor io.Closer, interfaceIndex int, verbose bool, ch chan<- minheap.Packet, sem chan struct{}) {
+	defer close(ch)
+
 	for {
+		if sem != nil {
+			sem <- struct{}{}
+		}
 		data, captureInfo, err := reader.ReadPacketData()
+		if sem != nil {
+			<-sem
+		}
 		if err != nil {
 			if err == io.EOF {
 				if verbose {
-					log.Printf("%s: done (closing)\n", inputFile.Name())
+					logVerbose("%s: done (closing)\n", inputFile.Name())
 				}
-				inputFile.Close()
-
-				return minheap.Packet{}, io.EOF
+				closeInput(inputFile, decompressor)
+				return
 			}
 			if verbose {
-				log.Printf("%s: %v (skipping this packet)\n", inputFile.Name(), err)
+				logVerbose("%s: %v (skipping this packet)\n", inputFile.Name(), err)
 			}
 			// skip errors
 			continue
 		}
 
-		timestamp := captureInfo.Timestamp.UnixNano()
-		oneHour := int64(time.Nanosecond * time.Hour)
+		if len(data) == 0 {
+			if verbose {
+				logVerbose("%s: empty data (skipping this packet)\n", inputFile.Name())
+			}
+			continue
+		}
+
+		captureInfo.InterfaceIndex = interfaceIndex
 
-		if !isInit && timestamp+oneHour < previousTimestamp {
+		ch <- minheap.Packet{
+			Timestamp:      captureInfo.Timestamp.UnixNano(),
+			CaptureInfo:    captureInfo,
+			Data:           data,
+			InputFile:      inputFile,
+			InterfaceIndex: interfaceIndex,
+		}
+	}
+}
+
+// decodeNgReaderSource is decodeReaderSource's pcapng counterpart: it also
+// remaps each packet's interface ID by interfaceBase so packets from
+// different pcapng input files never collide in the output file. See
+// decodeReaderSource for why sem is only held around ReadPacketData.
+func decodeNgReaderSource(reader *pcapgo.NgReader, inputFile *os.File, decompressor io.Closer, interfaceBase int, verbose bool, ch chan<- minheap.Packet, sem chan struct{}) {
+	defer close(ch)
+
+	for {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		data, captureInfo, err := reader.ReadPacketData()
+		if sem != nil {
+			<-sem
+		}
+		if err != nil {
+			if err == io.EOF {
+				if verbose {
+					logVerbose("%s: done (closing)\n", inputFile.Name())
+				}
+				closeInput(inputFile, decompressor)
+				return
+			}
 			if verbose {
-				log.Printf("%s: illegal packet timestamp %v - more than an hour before the previous packet's timestamp %v (skipping this packet)\n",
-					inputFile.Name(),
-					captureInfo.Timestamp.UTC(),
-					time.Unix(0, previousTimestamp).UTC())
+				logVerbose("%s: %v (skipping this packet)\n", inputFile.Name(), err)
 			}
-			// skip errors
 			continue
 		}
+
 		if len(data) == 0 {
 			if verbose {
-				log.Printf("%s: empty data (skipping this packet)\n", inputFile.Name())
+				logVerbose("%s: empty data (skipping this packet)\n", inputFile.Name())
 			}
-			// skip errors
 			continue
 		}
 
-		return minheap.Packet{
-			Timestamp:   timestamp,
-			CaptureInfo: captureInfo,
-			Data:        data,
-			Reader:      reader,
-			InputFile:   inputFile,
-		}, nil
+		outputInterfaceIndex := interfaceBase + captureInfo.InterfaceIndex
+		captureInfo.InterfaceIndex = outputInterfaceIndex
+
+		ch <- minheap.Packet{
+			Timestamp:      captureInfo.Timestamp.UnixNano(),
+			CaptureInfo:    captureInfo,
+			Data:           data,
+			InputFile:      inputFile,
+			InterfaceIndex: outputInterfaceIndex,
+		}
 	}
 }
 
-func write(writer *pcapgo.Writer, packetToWrite minheap.Packet, verbose bool) {
-	err := writer.WritePacket(packetToWrite.CaptureInfo, packetToWrite.Data)
+func isLegalTimestamp(inputFile *os.File, captureInfo gopacket.CaptureInfo, verbose bool, isInit bool) bool {
+	timestamp := captureInfo.Timestamp.UnixNano()
+	oneHour := int64(time.Nanosecond * time.Hour)
+
+	if !isInit && timestamp+oneHour < previousTimestamp {
+		if verbose {
+			logVerbose("%s: illegal packet timestamp %v - more than an hour before the previous packet's timestamp %v (skipping this packet)\n",
+				inputFile.Name(),
+				captureInfo.Timestamp.UTC(),
+				time.Unix(0, previousTimestamp).UTC())
+		}
+		return false
+	}
+	return true
+}
+
+// dedupFingerprint identifies a packet for --dedup purposes: its length plus
+// a hash of its data past the configurable offset, so e.g. differing
+// VLAN/MPLS headers can be ignored by skipping past them.
+type dedupFingerprint struct {
+	length int
+	hash   uint64
+}
+
+// dedupEntry is one fingerprint still inside the dedup window, kept in
+// arrival order in deduplicator.ring; evictBefore scans the whole ring on
+// every packet to drop the entries that have left the window.
+type dedupEntry struct {
+	timestamp   int64
+	fingerprint dedupFingerprint
+}
+
+// deduplicator drops packets that are a near-exact repeat of one already
+// seen within the last window of time - the common case when merging
+// captures from multiple taps/SPAN ports that see the same traffic. A packet
+// matches any fingerprint still in the ring, i.e. any not older than window
+// before its own timestamp; packets need not arrive in strictly sorted
+// timestamp order (see evictBefore).
+type deduplicator struct {
+	window time.Duration
+	offset int
+
+	ring    []dedupEntry
+	seen    map[dedupFingerprint][]int64
+	dropped int64
+}
+
+func newDeduplicator(window time.Duration, offset int) *deduplicator {
+	return &deduplicator{window: window, offset: offset, seen: map[dedupFingerprint][]int64{}}
+}
+
+// isDuplicate reports whether a packet with this timestamp and data matches
+// one already seen within the last window, recording it either way.
+func (d *deduplicator) isDuplicate(timestamp int64, data []byte) bool {
+	d.evictBefore(timestamp - int64(d.window))
+
+	fp := d.fingerprintFor(data)
+	duplicate := len(d.seen[fp]) > 0
+
+	d.ring = append(d.ring, dedupEntry{timestamp: timestamp, fingerprint: fp})
+	d.seen[fp] = append(d.seen[fp], timestamp)
+
+	if duplicate {
+		d.dropped++
+	}
+	return duplicate
+}
+
+func (d *deduplicator) fingerprintFor(data []byte) dedupFingerprint {
+	tail := data
+	if d.offset < len(data) {
+		tail = data[d.offset:]
+	} else {
+		tail = nil
+	}
+	return dedupFingerprint{length: len(data), hash: xxhash.Sum64(tail)}
+}
+
+// evictBefore drops every ring entry older than cutoff, wherever it sits in
+// the ring. Packets merged from multiple sources are only guaranteed to
+// arrive within an hour of the previous one written (isLegalTimestamp), not
+// in fully sorted order across sources with clock skew - so unlike a sorted
+// queue, this can't stop at the first non-expired entry, or stale entries
+// from a lagging source would never be evicted (unbounded memory growth)
+// and could wrongly match a later, unrelated packet as a duplicate.
+func (d *deduplicator) evictBefore(cutoff int64) {
+	kept := d.ring[:0]
+	for _, entry := range d.ring {
+		if entry.timestamp < cutoff {
+			d.forgetSeen(entry.fingerprint, entry.timestamp)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	d.ring = kept
+}
+
+// forgetSeen removes the one occurrence of timestamp recorded for fp, for a
+// ring entry being evicted.
+func (d *deduplicator) forgetSeen(fp dedupFingerprint, timestamp int64) {
+	times := d.seen[fp]
+	for i, t := range times {
+		if t == timestamp {
+			times = append(times[:i], times[i+1:]...)
+			break
+		}
+	}
+	if len(times) == 0 {
+		delete(d.seen, fp)
+	} else {
+		d.seen[fp] = times
+	}
+}
+
+func write(writer *pcapgo.Writer, ngWriter *pcapgo.NgWriter, packetToWrite minheap.Packet, verbose bool) {
+	if dedup != nil && dedup.isDuplicate(packetToWrite.Timestamp, packetToWrite.Data) {
+		if verbose {
+			logVerbose("%s: duplicate packet (skipping this packet)\n", packetToWrite.InputFile.Name())
+		}
+		previousTimestamp = packetToWrite.Timestamp
+		return
+	}
+
+	var err error
+	if ngWriter != nil {
+		err = ngWriter.WritePacket(packetToWrite.CaptureInfo, packetToWrite.Data)
+	} else {
+		err = writer.WritePacket(packetToWrite.CaptureInfo, packetToWrite.Data)
+	}
 	if err != nil && verbose { // skip errors
-		log.Printf("write error: %v (skipping this packet)\n", err)
+		logVerbose("write error: %v (skipping this packet)\n", err)
+	}
+	if progress != nil {
+		atomic.AddInt64(&progress.packetsWritten, 1)
 	}
 
 	previousTimestamp = packetToWrite.Timestamp
 }
+
+// splitMode selects exactly one of joincap's -S splitting strategies.
+type splitMode struct {
+	byCount    uint64
+	bySize     string
+	byInterval string
+	byFlow     bool
+}
+
+// splitcap reads a single pcap/pcapng input file and writes it back out as a
+// sequence of "<prefix>-%04d.pcap" chunks, split according to mode. It is
+// the inverse of joincap's merge: splitting a file "--by-count=N" and then
+// joining the resulting chunks back together reproduces the original file's
+// packet order and timestamps exactly.
+//
+// Chunks are always written as classic pcap, even for pcapng input: a
+// pcapng input's Section Header Block and Interface Description Block
+// metadata (see newNgWriterWithInterfaces) are not preserved across a
+// split, only its link type and snaplen.
+func splitcap(inputPath string, outputFilePath string, mode splitMode, verbose bool) error {
+	splitter, err := newSplitter(mode)
+	if err != nil {
+		return fmt.Errorf("invalid split mode: %v", err)
+	}
+
+	prefix := outputFilePath
+	if prefix == "-" || prefix == "" {
+		prefix = strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
+	}
+
+	inputFile, err := os.Open(inputPath)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %v", inputPath, err)
+	}
+
+	decompressed, decompressor, err := wrapInputDecompressor(inputFile)
+	if err != nil {
+		return fmt.Errorf("%s: %v", inputFile.Name(), err)
+	}
+
+	isNg, decompressed, err := detectPcapNg(decompressed)
+	if err != nil {
+		return fmt.Errorf("%s: %v", inputFile.Name(), err)
+	}
+
+	var linkType layers.LinkType
+	var snaplen uint32
+	var readPacketData func() ([]byte, gopacket.CaptureInfo, error)
+	if isNg {
+		reader, err := pcapgo.NewNgReader(decompressed, pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return fmt.Errorf("%s: %v", inputFile.Name(), err)
+		}
+		intf, err := reader.Interface(0)
+		if err != nil {
+			return fmt.Errorf("%s: %v", inputFile.Name(), err)
+		}
+		linkType = intf.LinkType
+		snaplen = intf.SnapLength
+		if snaplen == 0 {
+			// 0 means "unlimited" in pcapng, but a classic pcap header with
+			// a 0 snaplen is degenerate - fall back to the same cap used
+			// elsewhere for reading.
+			snaplen = maxSnaplen
+		}
+		readPacketData = reader.ReadPacketData
+	} else {
+		reader, err := pcapgo.NewReader(decompressed)
+		if err != nil {
+			return fmt.Errorf("%s: %v", inputFile.Name(), err)
+		}
+		// capture the input's own snaplen before SetSnaplen below raises it
+		// to maxSnaplen - that call only relaxes the reader's own internal
+		// sanity check against oversized packets, it isn't the value chunk
+		// output headers should claim.
+		snaplen = reader.Snaplen()
+		if snaplen == 0 {
+			snaplen = maxSnaplen
+		}
+		reader.SetSnaplen(maxSnaplen)
+		linkType = reader.LinkType()
+		readPacketData = reader.ReadPacketData
+	}
+	defer closeInput(inputFile, decompressor)
+
+	outputs := map[int]*splitOutput{}
+	defer func() {
+		for _, out := range outputs {
+			out.bufferedWriter.Flush()
+			out.file.Close()
+		}
+	}()
+
+	for {
+		data, captureInfo, err := readPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if verbose {
+				logVerbose("%s: %v (skipping this packet)\n", inputFile.Name(), err)
+			}
+			continue
+		}
+
+		fileIndex := splitter.fileIndexFor(data, captureInfo, linkType)
+		out, ok := outputs[fileIndex]
+		if !ok {
+			out, err = newSplitOutput(prefix, fileIndex, linkType, snaplen)
+			if err != nil {
+				return fmt.Errorf("cannot create split output: %v", err)
+			}
+			outputs[fileIndex] = out
+			if verbose {
+				logVerbose("splitting into %s\n", out.file.Name())
+			}
+		}
+
+		if err := out.writer.WritePacket(captureInfo, data); err != nil && verbose {
+			logVerbose("write error: %v (skipping this packet)\n", err)
+		}
+	}
+
+	return nil
+}
+
+// splitOutput is one "<prefix>-%04d.pcap" chunk being written by splitcap.
+type splitOutput struct {
+	file           *os.File
+	bufferedWriter *bufio.Writer
+	writer         *pcapgo.Writer
+}
+
+func newSplitOutput(prefix string, fileIndex int, linkType layers.LinkType, snaplen uint32) (*splitOutput, error) {
+	path := fmt.Sprintf("%s-%04d.pcap", prefix, fileIndex)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferedWriter := bufio.NewWriter(file)
+	writer := pcapgo.NewWriter(bufferedWriter)
+	writer.WriteFileHeader(snaplen, linkType)
+
+	return &splitOutput{file: file, bufferedWriter: bufferedWriter, writer: writer}, nil
+}
+
+// splitter decides which output chunk a packet belongs to, for whichever of
+// joincap's -S splitting strategies was requested.
+type splitter struct {
+	byCount    uint64
+	bySize     uint64
+	byInterval time.Duration
+	byFlow     bool
+
+	packetsInCurrent uint64
+	bytesInCurrent   uint64
+	windowStart      int64
+	currentIndex     int
+	flowIndices      map[string]int
+}
+
+func newSplitter(mode splitMode) (*splitter, error) {
+	set := 0
+	if mode.byCount > 0 {
+		set++
+	}
+	if mode.bySize != "" {
+		set++
+	}
+	if mode.byInterval != "" {
+		set++
+	}
+	if mode.byFlow {
+		set++
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of --by-count, --by-size, --by-interval or --by-flow is required")
+	}
+
+	s := &splitter{byCount: mode.byCount, byFlow: mode.byFlow}
+
+	if mode.bySize != "" {
+		size, err := humanize.ParseBytes(mode.bySize)
+		if err != nil {
+			return nil, fmt.Errorf("--by-size %q: %v", mode.bySize, err)
+		}
+		s.bySize = size
+	}
+
+	if mode.byInterval != "" {
+		interval, err := time.ParseDuration(mode.byInterval)
+		if err != nil {
+			return nil, fmt.Errorf("--by-interval %q: %v", mode.byInterval, err)
+		}
+		s.byInterval = interval
+	}
+
+	if mode.byFlow {
+		s.flowIndices = map[string]int{}
+	}
+
+	return s, nil
+}
+
+// fileIndexFor returns which output chunk data/captureInfo belongs in,
+// advancing the splitter's internal state as needed.
+func (s *splitter) fileIndexFor(data []byte, captureInfo gopacket.CaptureInfo, linkType layers.LinkType) int {
+	switch {
+	case s.byCount > 0:
+		if s.packetsInCurrent >= s.byCount {
+			s.currentIndex++
+			s.packetsInCurrent = 0
+		}
+		s.packetsInCurrent++
+		return s.currentIndex
+
+	case s.bySize > 0:
+		// 16 bytes is the size of a classic pcap per-packet record header.
+		recordSize := uint64(16 + len(data))
+		if s.bytesInCurrent > 0 && s.bytesInCurrent+recordSize > s.bySize {
+			s.currentIndex++
+			s.bytesInCurrent = 0
+		}
+		s.bytesInCurrent += recordSize
+		return s.currentIndex
+
+	case s.byInterval > 0:
+		timestamp := captureInfo.Timestamp.UnixNano()
+		if s.windowStart == 0 {
+			s.windowStart = timestamp
+		}
+		for timestamp >= s.windowStart+int64(s.byInterval) {
+			s.windowStart += int64(s.byInterval)
+			s.currentIndex++
+		}
+		return s.currentIndex
+
+	case s.byFlow:
+		key := flowKey(data, linkType)
+		index, ok := s.flowIndices[key]
+		if !ok {
+			index = len(s.flowIndices)
+			s.flowIndices[key] = index
+		}
+		return index
+
+	default:
+		return 0
+	}
+}
+
+// flowKey decodes just enough of the packet to build a 5-tuple bucket key
+// out of its network and transport layer flows.
+func flowKey(data []byte, linkType layers.LinkType) string {
+	packet := gopacket.NewPacket(data, linkType, gopacket.NoCopy)
+
+	var networkFlow, transportFlow gopacket.Flow
+	if networkLayer := packet.NetworkLayer(); networkLayer != nil {
+		networkFlow = networkLayer.NetworkFlow()
+	}
+	if transportLayer := packet.TransportLayer(); transportLayer != nil {
+		transportFlow = transportLayer.TransportFlow()
+	}
+
+	return networkFlow.String() + "/" + transportFlow.String()
+}
@@ -1,14 +1,135 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
 )
 
+// writeSyntheticPcap writes a classic pcap file of packetCount minimal
+// packets to path, for tests that need more packets than any checked-in
+// fixture (e.g. more than channelBufferSize) without depending on one.
+func writeSyntheticPcap(t *testing.T, path string, packetCount int) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 14)
+	for i := 0; i < packetCount; i++ {
+		captureInfo := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(0, int64(i)*int64(time.Microsecond)),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		if err := writer.WritePacket(captureInfo, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeSyntheticTCPPcap writes a classic pcap file of packetCount minimal
+// Ethernet/IPv4/TCP packets to path, each a SYN to destPort, for tests that
+// need BPF-filterable traffic without depending on a checked-in fixture.
+func writeSyntheticTCPPcap(t *testing.T, path string, packetCount int, destPort layers.TCPPort) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	eth := layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := layers.IPv4{
+		Version:  4,
+		IHL:      5,
+		TTL:      64,
+		SrcIP:    net.IPv4(10, 0, 0, 1),
+		DstIP:    net.IPv4(10, 0, 0, 2),
+		Protocol: layers.IPProtocolTCP,
+	}
+	tcp := layers.TCP{
+		SrcPort: layers.TCPPort(12345),
+		DstPort: destPort,
+		SYN:     true,
+		Window:  1024,
+	}
+	tcp.SetNetworkLayerForChecksum(&ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	for i := 0; i < packetCount; i++ {
+		buf.Clear()
+		if err := gopacket.SerializeLayers(buf, opts, &eth, &ip, &tcp); err != nil {
+			t.Fatal(err)
+		}
+		captureInfo := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(0, int64(i)*int64(time.Microsecond)),
+			CaptureLength: len(buf.Bytes()),
+			Length:        len(buf.Bytes()),
+		}
+		if err := writer.WritePacket(captureInfo, buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeSyntheticPcapDistinct is like writeSyntheticPcap, but each packet's
+// data encodes its own index, so no two packets within the file collide
+// under a content-based fingerprint (unlike writeSyntheticPcap's identical
+// all-zero packets) - needed by tests that exercise --dedup, where the
+// property under test is that only packets actually duplicated across
+// inputs get dropped.
+func writeSyntheticPcapDistinct(t *testing.T, path string, packetCount int) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < packetCount; i++ {
+		data := []byte{byte(i >> 24), byte(i >> 16), byte(i >> 8), byte(i), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		captureInfo := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(0, int64(i)*int64(time.Microsecond)),
+			CaptureLength: len(data),
+			Length:        len(data),
+		}
+		if err := writer.WritePacket(captureInfo, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func packetCount(pcapPath string) (uint64, error) {
 	inputFile, err := os.Open(pcapPath)
 	if err != nil {
@@ -75,7 +196,14 @@ func TestCount(t *testing.T) {
 	outputFile.Close()
 	defer os.Remove(outputFile.Name())
 
-	inputFilePath := "pcap_examples/ok.pcap"
+	inputFile, err := ioutil.TempFile("", "joincap_input_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile.Close()
+	defer os.Remove(inputFile.Name())
+	inputFilePath := inputFile.Name()
+	writeSyntheticPcap(t, inputFilePath, 10)
 
 	joincap([]string{"joincap", "-w", outputFile.Name(), inputFilePath, inputFilePath})
 
@@ -103,7 +231,14 @@ func TestOrder(t *testing.T) {
 	outputFile.Close()
 	defer os.Remove(outputFile.Name())
 
-	inputFilePath := "pcap_examples/ok.pcap"
+	inputFile, err := ioutil.TempFile("", "joincap_input_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile.Close()
+	defer os.Remove(inputFile.Name())
+	inputFilePath := inputFile.Name()
+	writeSyntheticPcap(t, inputFilePath, 10)
 
 	joincap([]string{"joincap", "-w", outputFile.Name(), inputFilePath, inputFilePath})
 
@@ -122,4 +257,260 @@ func TestOrder(t *testing.T) {
 	if !isOutputOrdered {
 		t.FailNow()
 	}
+}
+
+// TestDedup merging a file with itself and --dedup should drop every
+// packet from the second copy, since each is an exact duplicate of one in
+// the first
+func TestDedup(t *testing.T) {
+	outputFile, err := ioutil.TempFile("", "joincap_output_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	inputFile, err := ioutil.TempFile("", "joincap_input_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile.Close()
+	defer os.Remove(inputFile.Name())
+	inputFilePath := inputFile.Name()
+	writeSyntheticPcapDistinct(t, inputFilePath, 10)
+
+	joincap([]string{"joincap", "--dedup=1h", "-w", outputFile.Name(), inputFilePath, inputFilePath})
+
+	inputPacketCount, err := packetCount(inputFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputPacketCount, err := packetCount(outputFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inputPacketCount != outputPacketCount {
+		t.Fatalf("inputPacketCount != outputPacketCount (%d != %d)\n", inputPacketCount, outputPacketCount)
+	}
+}
+
+// TestDedupOffsetRejectsNegative a negative --dedup-offset used to panic
+// inside fingerprintFor's data[d.offset:] slice; it should now be rejected
+// as a usage error before any input file is even opened.
+func TestDedupOffsetRejectsNegative(t *testing.T) {
+	err := joincap([]string{"joincap", "--dedup=1h", "--dedup-offset=-1", "-w", "-", "does-not-matter.pcap"})
+	if err == nil {
+		t.Fatal("expected an error for a negative --dedup-offset, got nil")
+	}
+}
+
+// TestDeduplicatorEvictsOutOfOrderEntries is a regression test: merged
+// packets only arrive within an hour of the previous one written
+// (isLegalTimestamp), not in fully sorted order across sources with clock
+// skew, so the ring can end up with a later, still-fresh entry sitting in
+// front of an earlier, already-stale one. evictBefore used to stop at the
+// first non-expired entry it scanned, so that stale entry behind it was
+// never evicted - it sat in d.seen forever, ready to wrongly match a much
+// later, unrelated packet as a "duplicate".
+func TestDeduplicatorEvictsOutOfOrderEntries(t *testing.T) {
+	d := newDeduplicator(10, 0)
+
+	staleFingerprint := []byte{5, 6, 7, 8}
+
+	d.isDuplicate(1000, []byte{1, 2, 3, 4}) // recent, stays in the ring
+	d.isDuplicate(10, staleFingerprint)     // much older, arrives later (clock skew)
+
+	// within window of the first entry (1000), but the second entry (10) is
+	// long expired; a scan that stops at the first non-expired entry would
+	// never reach it
+	d.isDuplicate(1005, []byte{9, 9, 9, 9})
+
+	if len(d.seen[d.fingerprintFor(staleFingerprint)]) != 0 {
+		t.Fatal("stale out-of-order entry was never evicted from d.seen")
+	}
+
+	// far outside every window above: must not match the long-evicted entry
+	if d.isDuplicate(100000, staleFingerprint) {
+		t.Fatal("packet wrongly matched an entry that should have been evicted long ago")
+	}
+}
+
+// TestConcurrencyCap merging with -j should produce the same result as
+// merging without it
+func TestConcurrencyCap(t *testing.T) {
+	outputFile, err := ioutil.TempFile("", "joincap_output_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	inputFile, err := ioutil.TempFile("", "joincap_input_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputFile.Close()
+	defer os.Remove(inputFile.Name())
+	inputFilePath := inputFile.Name()
+	writeSyntheticPcap(t, inputFilePath, 10)
+
+	joincap([]string{"joincap", "-j", "1", "-w", outputFile.Name(), inputFilePath, inputFilePath})
+
+	inputPacketCount, err := packetCount(inputFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputPacketCount, err := packetCount(outputFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inputPacketCount*2 != outputPacketCount {
+		t.Fatalf("inputPacketCount*2 != outputPacketCount (%d != %d)\n", inputPacketCount*2, outputPacketCount)
+	}
+}
+
+// TestConcurrencyCapDoesNotDeadlock is a regression test: -j N < input file
+// count used to hang forever once an input had more packets than the decode
+// channel buffer (channelBufferSize), because the concurrency semaphore was
+// held for a whole file's decode instead of just each packet read, starving
+// every other input's goroutine of a slot while it sat blocked on a full
+// channel that nothing was draining yet.
+func TestConcurrencyCapDoesNotDeadlock(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "joincap_deadlock_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var inputs []string
+	for i, count := range []int{channelBufferSize * 2, 10, 10} {
+		path := filepath.Join(tmpDir, fmt.Sprintf("in%d.pcap", i))
+		writeSyntheticPcap(t, path, count)
+		inputs = append(inputs, path)
+	}
+
+	outputFile, err := ioutil.TempFile("", "joincap_output_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	args := append([]string{"joincap", "-j", "1", "-w", outputFile.Name()}, inputs...)
+
+	done := make(chan error, 1)
+	go func() { done <- joincap(args) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("joincap with -j 1 deadlocked")
+	}
+}
+
+// TestSplitJoinRoundTrip splitting a pcap file --by-count and then
+// merging the chunks back with joincap should reproduce the original file
+// byte-for-byte
+func TestSplitJoinRoundTrip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "joincap_splitcap_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inputFilePath := filepath.Join(tmpDir, "input.pcap")
+	writeSyntheticPcapDistinct(t, inputFilePath, 10)
+	prefix := filepath.Join(tmpDir, "chunk")
+
+	if err := joincap([]string{"joincap", "-S", "--by-count=1", "-w", prefix, inputFilePath}); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := filepath.Glob(prefix + "-*.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(chunks)
+
+	rejoinedFile, err := ioutil.TempFile("", "joincap_output_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejoinedFile.Close()
+	defer os.Remove(rejoinedFile.Name())
+
+	args := append([]string{"joincap", "-w", rejoinedFile.Name()}, chunks...)
+	if err := joincap(args); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := ioutil.ReadFile(inputFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejoined, err := ioutil.ReadFile(rejoinedFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(original, rejoined) {
+		t.Fatalf("rejoined file does not match original byte-for-byte\n")
+	}
+}
+
+// TestMergePreservesInputSnaplen is a regression test: the classic-pcap
+// merge writer used to hardcode maxSnaplen in its global header regardless
+// of what the input actually declared, so merging a file with its own
+// snaplen no longer reproduced that snaplen in the output.
+func TestMergePreservesInputSnaplen(t *testing.T) {
+	const inputSnaplen = 96
+
+	inputFile, err := ioutil.TempFile("", "joincap_input_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputPath := inputFile.Name()
+	defer os.Remove(inputPath)
+
+	writer := pcapgo.NewWriter(inputFile)
+	if err := writer.WriteFileHeader(inputSnaplen, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 14)
+	captureInfo := gopacket.CaptureInfo{Timestamp: time.Unix(0, 0), CaptureLength: len(data), Length: len(data)}
+	if err := writer.WritePacket(captureInfo, data); err != nil {
+		t.Fatal(err)
+	}
+	inputFile.Close()
+
+	outputFile, err := ioutil.TempFile("", "joincap_output_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputFile.Close()
+	defer os.Remove(outputFile.Name())
+
+	if err := joincap([]string{"joincap", "-w", outputFile.Name(), inputPath}); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := os.Open(outputFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	reader, err := pcapgo.NewReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Snaplen() != inputSnaplen {
+		t.Fatalf("merged output snaplen = %d, want %d (the input's own snaplen)\n", reader.Snaplen(), inputSnaplen)
+	}
 }
\ No newline at end of file
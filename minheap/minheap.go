@@ -0,0 +1,51 @@
+// Package minheap implements a min-heap of packets ordered by timestamp,
+// used to merge packets from multiple input files in timestamp order.
+package minheap
+
+import (
+	"os"
+
+	"github.com/google/gopacket"
+)
+
+// Packet is a single packet decoded from one of the input files, together
+// with enough context to pull the next packet from the same source and to
+// route it to the right interface in the output file. Each input file is
+// decoded by its own goroutine, which sends Packets to Channel in order;
+// pulling the next packet from the same source is a receive on that
+// channel rather than a direct read, so the decode and the k-way merge can
+// run concurrently.
+type Packet struct {
+	Timestamp   int64
+	CaptureInfo gopacket.CaptureInfo
+	Data        []byte
+	InputFile   *os.File
+	// InterfaceIndex is this packet's interface ID in the output file.
+	InterfaceIndex int
+	// Channel is the decode goroutine's output channel for this packet's
+	// input file. It is closed once that file is exhausted.
+	Channel chan Packet
+}
+
+// PacketHeap is a min-heap of Packets, ordered by Timestamp.
+type PacketHeap []Packet
+
+func (h PacketHeap) Len() int { return len(h) }
+
+func (h PacketHeap) Less(i, j int) bool { return h[i].Timestamp < h[j].Timestamp }
+
+func (h PacketHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+// Push implements heap.Interface.
+func (h *PacketHeap) Push(x interface{}) {
+	*h = append(*h, x.(Packet))
+}
+
+// Pop implements heap.Interface.
+func (h *PacketHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}